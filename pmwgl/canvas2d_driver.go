@@ -0,0 +1,102 @@
+package pmwgl
+
+import (
+	"fmt"
+	"syscall/js"
+)
+
+// canvas2dDriver is a fallback Driver for browsers without WebGL. Each
+// "texture" is an offscreen <canvas> the loaded tile image is drawn onto
+// once in UploadImage; DrawQuads then blits those offscreen canvases onto
+// the visible one with CanvasRenderingContext2D.drawImage. There's no
+// batching, multi-texture-unit trick, or custom shader support here, just
+// enough to get a working map on screen.
+type canvas2dDriver struct {
+	canvas js.Value
+	ctx    js.Value
+
+	frameCb    js.Callback
+	frameFn    func()
+	frameCbSet bool
+}
+
+// newCanvas2dDriver wraps canvasEl's 2D context as a Driver.
+func newCanvas2dDriver(canvasEl js.Value) (*canvas2dDriver, error) {
+	ctx := canvasEl.Call("getContext", "2d")
+	if ctx.IsNull() || ctx.IsUndefined() {
+		return nil, fmt.Errorf("pmwgl: canvas 2d context unavailable")
+	}
+
+	return &canvas2dDriver{canvas: canvasEl, ctx: ctx}, nil
+}
+
+func (d *canvas2dDriver) CreateTexture(width, height int) js.Value {
+	off := js.Global().Get("document").Call("createElement", "canvas")
+	off.Set("width", width)
+	off.Set("height", height)
+	// Same semi-transparent black placeholder wglDriver fills with (alpha
+	// 30/255); UploadImage's resize clears it before drawing the real image.
+	octx := off.Call("getContext", "2d")
+	octx.Set("fillStyle", "rgba(0, 0, 0, 0.118)")
+	octx.Call("fillRect", 0, 0, width, height)
+	return off
+}
+
+func (d *canvas2dDriver) UploadImage(tex js.Value, img js.Value) {
+	width := img.Get("width").Int()
+	height := img.Get("height").Int()
+	tex.Set("width", width)
+	tex.Set("height", height)
+	tex.Call("getContext", "2d").Call("drawImage", img, 0, 0)
+}
+
+func (d *canvas2dDriver) DrawQuads(quads []Quad, clear bool, opacity float32, composite CompositeMode) {
+	width, height := d.Viewport()
+
+	if clear {
+		d.ctx.Call("clearRect", 0, 0, width, height)
+	}
+
+	d.ctx.Set("globalAlpha", float64(opacity))
+	d.ctx.Set("globalCompositeOperation", canvas2dCompositeOp(composite))
+
+	for _, q := range quads {
+		d.ctx.Call("drawImage", q.Texture, float64(q.X), float64(q.Y), float64(q.Width), float64(q.Height))
+	}
+}
+
+func (d *canvas2dDriver) RequestFrame(cb func()) {
+	d.frameFn = cb
+	if !d.frameCbSet {
+		d.frameCb = js.NewCallback(func(args []js.Value) {
+			if d.frameFn != nil {
+				d.frameFn()
+			}
+		})
+		d.frameCbSet = true
+	}
+	js.Global().Call("requestAnimationFrame", d.frameCb)
+}
+
+func (d *canvas2dDriver) Viewport() (width, height float64) {
+	width = d.canvas.Get("width").Float()
+	height = d.canvas.Get("height").Float()
+	return
+}
+
+// canvas2dCompositeOp maps a CompositeMode to the nearest
+// globalCompositeOperation Canvas2D supports.
+func canvas2dCompositeOp(mode CompositeMode) string {
+	switch mode {
+	case CompositeModeLighter:
+		return "lighter"
+	case CompositeModeCopy:
+		return "copy"
+	case CompositeModeMultiply:
+		return "multiply"
+	case CompositeModeDestinationOut:
+		return "destination-out"
+	default: // CompositeModeSourceOver
+		return "source-over"
+	}
+}
@@ -0,0 +1,23 @@
+package pmwgl
+
+import "testing"
+
+func TestCanvas2dCompositeOp(t *testing.T) {
+	tests := []struct {
+		mode CompositeMode
+		want string
+	}{
+		{CompositeModeSourceOver, "source-over"},
+		{CompositeModeLighter, "lighter"},
+		{CompositeModeCopy, "copy"},
+		{CompositeModeMultiply, "multiply"},
+		{CompositeModeDestinationOut, "destination-out"},
+		{CompositeMode(-1), "source-over"}, // unknown modes fall back to source-over
+	}
+
+	for _, tt := range tests {
+		if got := canvas2dCompositeOp(tt.mode); got != tt.want {
+			t.Errorf("canvas2dCompositeOp(%v) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package pmwgl
+
+import "syscall/js"
+
+// Driver is the graphics backend TileRenderer draws through. Splitting it
+// out of TileRenderer (the same way ebiten splits its Context from the
+// concrete contextImpl) keeps tile scheduling, caching, and cancellation
+// testable on non-wasm targets, and leaves room for backends other than
+// WebGL.
+type Driver interface {
+	// CreateTexture allocates a backend texture handle, sized width x
+	// height and pre-filled with placeholder pixels, ready for UploadImage
+	// once the real tile image has loaded.
+	CreateTexture(width, height int) js.Value
+
+	// UploadImage uploads a loaded <img> element into tex.
+	UploadImage(tex js.Value, img js.Value)
+
+	// DrawQuads draws quads against the current render target. If clear is
+	// set the target is cleared first; opacity and composite apply to the
+	// whole batch, matching a single Layer's settings.
+	DrawQuads(quads []Quad, clear bool, opacity float32, composite CompositeMode)
+
+	// RequestFrame schedules cb to run on the next animation frame.
+	RequestFrame(cb func())
+
+	// Viewport returns the current width/height of the render target.
+	Viewport() (width, height float64)
+}
+
+// Quad is one positioned, textured tile quad handed to Driver.DrawQuads.
+// Position and size are already in target pixel space (origin top-left),
+// matching the CPU-side transform TileRenderer does before batching.
+type Quad struct {
+	Texture js.Value
+	X, Y    float32
+	Width   float32
+	Height  float32
+}
@@ -0,0 +1,134 @@
+package pmwgl
+
+import (
+	"fmt"
+	"syscall/js"
+
+	"github.com/pichiw/pichiwmap"
+)
+
+// Framebuffer is an offscreen render target backed by a single color
+// texture. It's used for PNG snapshots, post-processing passes, and
+// compositing per-layer tile stacks before they're drawn to the screen.
+type Framebuffer struct {
+	gl          *WebGL
+	framebuffer js.Value
+	texture     js.Value
+	width       int
+	height      int
+}
+
+// NewFramebuffer creates a framebuffer with a width x height color texture
+// attached at COLOR_ATTACHMENT0.
+func NewFramebuffer(gl *WebGL, width, height int) (*Framebuffer, error) {
+	tex := gl.CreateTexture()
+	gl.BindTexture(gl.Texture2D, tex)
+	gl.TexImage2DEmpty(gl.Texture2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UnsignedByte)
+	gl.TexParameteri(gl.Texture2D, gl.TextureWrapS, gl.ClampToEdge)
+	gl.TexParameteri(gl.Texture2D, gl.TextureWrapT, gl.ClampToEdge)
+	gl.TexParameteri(gl.Texture2D, gl.TextureMinFilter, gl.Linear)
+
+	fb := gl.CreateFramebuffer()
+	gl.BindFramebuffer(gl.Framebuffer, fb)
+	gl.FramebufferTexture2D(gl.Framebuffer, gl.ColorAttachment0, gl.Texture2D, tex, 0)
+
+	if status := gl.CheckFramebufferStatus(gl.Framebuffer); status != gl.FramebufferComplete {
+		return nil, fmt.Errorf("pmwgl: framebuffer incomplete (status %v)", status)
+	}
+
+	return &Framebuffer{
+		gl:          gl,
+		framebuffer: fb,
+		texture:     tex,
+		width:       width,
+		height:      height,
+	}, nil
+}
+
+// Bind makes f the active render target.
+func (f *Framebuffer) Bind() {
+	f.gl.BindFramebuffer(f.gl.Framebuffer, f.framebuffer)
+}
+
+// Unbind restores screen as the active render target.
+func (f *Framebuffer) Unbind(screen js.Value) {
+	f.gl.BindFramebuffer(f.gl.Framebuffer, screen)
+}
+
+// Texture returns f's color attachment, suitable for sampling in a later
+// pass (e.g. a post-processing shader or compositing to screen).
+func (f *Framebuffer) Texture() js.Value {
+	return f.texture
+}
+
+// ReadPixels reads back f's RGBA pixels, e.g. to encode a PNG snapshot of
+// the current map. The framebuffer bound before the call (screen or
+// otherwise) is restored before returning, so callers don't have to know
+// or care what RenderToFramebuffer/updateGl last left bound.
+func (f *Framebuffer) ReadPixels() []byte {
+	prev := f.gl.GetParameter(f.gl.FramebufferBinding)
+	f.Bind()
+	defer f.Unbind(prev)
+
+	px := make([]byte, f.width*f.height*4)
+	dst := js.TypedArrayOf(px)
+	defer dst.Release()
+
+	f.gl.ReadPixels(0, 0, f.width, f.height, f.gl.RGBA, f.gl.UnsignedByte, dst)
+	return px
+}
+
+// RenderToFramebuffer renders tiles at zoom into fb instead of the screen,
+// then restores the screen framebuffer and viewport. Framebuffers are a
+// WebGL concept: this is a no-op under a non-WebGL Driver such as
+// canvas2dDriver.
+func (t *TileRenderer) RenderToFramebuffer(fb *Framebuffer, zoom int, tiles map[string]*pichiwmap.Tile) {
+	w, ok := t.driver.(*wglDriver)
+	if !ok {
+		return
+	}
+
+	toDraw := t.drawInfoFor(zoom, tiles)
+	quads := quadsFor(toDraw, float64(fb.width), float64(fb.height))
+
+	screenWidth, screenHeight := w.Viewport()
+
+	fb.Bind()
+	w.draw(quads, true, 1, CompositeModeSourceOver, float64(fb.width), float64(fb.height))
+
+	fb.Unbind(w.screenFramebuffer)
+	w.setViewport(screenWidth, screenHeight)
+}
+
+// drawInfoFor loads (or reuses from cache) the textures for tiles at zoom
+// and returns them as drawInfo centred on the origin, the same shape
+// RenderTiles builds for the screen pass.
+func (t *TileRenderer) drawInfoFor(zoom int, tiles map[string]*pichiwmap.Tile) []*drawInfo {
+	var toDraw []*drawInfo
+
+	for _, tile := range tiles {
+		if tile.Zoom != zoom {
+			continue
+		}
+
+		u := tile.URL.String()
+
+		var txi *textureInfo
+		v, ok := t.cache.Get(u)
+		if ok {
+			txi = v.(*textureInfo)
+		} else {
+			txi = t.loadImage(u, t.imageLoadCallback)
+			t.cache.Add(u, txi)
+		}
+
+		toDraw = append(toDraw, &drawInfo{
+			Texture: txi,
+			DX:      tile.DX,
+			DY:      tile.DY,
+			Scale:   tile.Scale,
+		})
+	}
+
+	return toDraw
+}
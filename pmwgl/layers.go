@@ -0,0 +1,137 @@
+package pmwgl
+
+import (
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pichiw/pichiwmap"
+)
+
+// CompositeMode selects how a layer's colors combine with whatever is
+// already in the framebuffer, modeled on ebiten's CompositeMode set.
+type CompositeMode int
+
+const (
+	// CompositeModeSourceOver alpha-blends the layer over the destination;
+	// the usual mode for a base map plus semi-transparent overlays.
+	CompositeModeSourceOver CompositeMode = iota
+	// CompositeModeLighter adds the layer's color to the destination.
+	CompositeModeLighter
+	// CompositeModeCopy replaces the destination with the layer's color.
+	CompositeModeCopy
+	// CompositeModeMultiply multiplies the layer's color into the
+	// destination, useful for tinting/shading passes.
+	CompositeModeMultiply
+	// CompositeModeDestinationOut erases destination pixels where the
+	// layer is opaque.
+	CompositeModeDestinationOut
+)
+
+// blendFuncFor returns the BlendFunc(sfactor, dfactor) and BlendEquation
+// GL enums for mode. Unlike canvas2dCompositeOp's string mapping, this one
+// reads its return values off *WebGL's resolved GL enum fields, so
+// exercising it needs a real (or stubbed) WebGL context rather than a plain
+// unit test.
+func (w *wglDriver) blendFuncFor(mode CompositeMode) (sfactor, dfactor, equation int) {
+	switch mode {
+	case CompositeModeLighter:
+		return w.gl.SrcAlpha, w.gl.One, w.gl.FuncAdd
+	case CompositeModeCopy:
+		return w.gl.One, w.gl.Zero, w.gl.FuncAdd
+	case CompositeModeMultiply:
+		return w.gl.DstColor, w.gl.Zero, w.gl.FuncAdd
+	case CompositeModeDestinationOut:
+		return w.gl.Zero, w.gl.OneMinusSrcAlpha, w.gl.FuncAdd
+	default: // CompositeModeSourceOver
+		return w.gl.SrcAlpha, w.gl.OneMinusSrcAlpha, w.gl.FuncAdd
+	}
+}
+
+// Layer is one tile source in a multi-layer render pass, e.g. an OSM base
+// layer plus a semi-transparent weather radar overlay. Callers should keep
+// the same *Layer around across frames (the same way TileRenderer itself
+// keeps toDraw) so its texture cache and in-flight loads persist and
+// cancellations don't cross-contaminate between layers.
+type Layer struct {
+	Tiles     map[string]*pichiwmap.Tile
+	Opacity   float32
+	Composite CompositeMode
+
+	cache  *lru.Cache
+	toDraw []*drawInfo
+}
+
+// RenderLayers renders multiple tile layers in a single frame, compositing
+// each on top of the last according to its Opacity and Composite mode.
+func (t *TileRenderer) RenderLayers(zoom int, layers []*Layer) {
+	// Abandoning RenderTiles mode orphans its in-flight loads unless we
+	// cancel them here; RenderTiles won't be called again to do it for us.
+	if t.layers == nil {
+		cancelToDraw(t.toDraw, t.cache)
+		t.toDraw = nil
+	}
+
+	// Cancel any layer that was part of a previous call but isn't part of
+	// this one anymore (e.g. an overlay toggled off); it won't get
+	// prepareLayer'd below, so nothing else will stop its tiles downloading.
+	stillActive := make(map[*Layer]bool, len(layers))
+	for _, l := range layers {
+		stillActive[l] = true
+	}
+	for _, l := range t.layers {
+		if !stillActive[l] {
+			cancelToDraw(l.toDraw, l.cache)
+			l.toDraw = nil
+		}
+	}
+
+	for _, l := range layers {
+		t.prepareLayer(zoom, l)
+	}
+
+	t.layers = layers
+	t.requestAnimationFrame()
+}
+
+// prepareLayer mirrors RenderTiles' cache/cancellation bookkeeping, scoped
+// to a single layer's own cache.
+func (t *TileRenderer) prepareLayer(zoom int, l *Layer) {
+	if l.cache == nil {
+		cache, err := lru.New(150)
+		if err != nil {
+			// lru.New only errors for a non-positive size.
+			panic(err)
+		}
+		l.cache = cache
+	}
+
+	for _, td := range l.toDraw {
+		if _, ok := l.Tiles[td.Texture.URL]; !ok {
+			if td.Texture.Cancel() {
+				l.cache.Remove(td.Texture.URL)
+			}
+		}
+	}
+
+	l.toDraw = nil
+
+	for _, tile := range l.Tiles {
+		u := tile.URL.String()
+
+		var txi *textureInfo
+		v, ok := l.cache.Get(u)
+		if ok {
+			txi = v.(*textureInfo)
+		} else {
+			txi = t.loadImage(u, t.imageLoadCallback)
+			l.cache.Add(u, txi)
+		}
+
+		if tile.Zoom == zoom {
+			l.toDraw = append(l.toDraw, &drawInfo{
+				Texture: txi,
+				DX:      tile.DX,
+				DY:      tile.DY,
+				Scale:   tile.Scale,
+			})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package pmwgl
+
+import "syscall/js"
+
+// glStateCache tracks the GL bindings wglDriver itself set last frame so
+// redundant calls into the WASM boundary can be short-circuited. This
+// mirrors the approach ebiten's WebGL driver uses: the driver's program,
+// per-unit texture, viewport, and blend-mode changes go through a setter
+// here instead of straight to gl.*, and the setter is a no-op if the value
+// hasn't actually changed. The array buffer is not cached: draw binds
+// batchBuffer directly on every call.
+type glStateCache struct {
+	lastProgram      js.Value
+	hasLastProgram   bool
+	lastTextures     [maxBatchTextures]js.Value
+	lastViewportW    float64
+	lastViewportH    float64
+	hasLastViewport  bool
+	lastBlendMode    CompositeMode
+	hasLastBlendMode bool
+}
+
+func newGLStateCache() *glStateCache {
+	return &glStateCache{}
+}
+
+// useProgram calls gl.UseProgram only if program isn't already current.
+func (w *wglDriver) useProgram(program js.Value) {
+	if w.state.hasLastProgram && program.Equal(w.state.lastProgram) {
+		return
+	}
+	w.gl.UseProgram(program)
+	w.state.lastProgram = program
+	w.state.hasLastProgram = true
+}
+
+// bindTextureUnit binds tex to the given texture unit, skipping the
+// ActiveTexture+BindTexture pair if that unit already holds tex.
+func (w *wglDriver) bindTextureUnit(unit int, tex js.Value) {
+	if !w.state.lastTextures[unit].IsUndefined() && tex.Equal(w.state.lastTextures[unit]) {
+		return
+	}
+	w.gl.ActiveTexture(w.gl.Texture0 + unit)
+	w.gl.BindTexture(w.gl.Texture2D, tex)
+	w.state.lastTextures[unit] = tex
+}
+
+// setViewport calls gl.Viewport only if the size actually changed.
+func (w *wglDriver) setViewport(width, height float64) {
+	if w.state.hasLastViewport && width == w.state.lastViewportW && height == w.state.lastViewportH {
+		return
+	}
+	w.gl.Viewport(0, 0, width, height)
+	w.state.lastViewportW = width
+	w.state.lastViewportH = height
+	w.state.hasLastViewport = true
+}
+
+// setBlendMode sets the blend func/equation pair for mode, skipping the GL
+// calls entirely if mode is already active. Callers are responsible for
+// gl.Enable(w.gl.Blend) beforehand; this only ever changes the function.
+func (w *wglDriver) setBlendMode(mode CompositeMode) {
+	if w.state.hasLastBlendMode && mode == w.state.lastBlendMode {
+		return
+	}
+	sfactor, dfactor, equation := w.blendFuncFor(mode)
+	w.gl.BlendEquation(equation)
+	w.gl.BlendFunc(sfactor, dfactor)
+	w.state.lastBlendMode = mode
+	w.state.hasLastBlendMode = true
+}
@@ -1,286 +1,242 @@
-package pmwgl
-
-import (
-	"sync"
-	"syscall/js"
-
-	lru "github.com/hashicorp/golang-lru"
-	"github.com/pichiw/pichiwmap"
-)
-
-// NewTileRenderer creates a new tile renderer
-func NewTileRenderer(canvasEl js.Value) (*TileRenderer, error) {
-	cache, err := lru.New(150)
-	if err != nil {
-		return nil, err
-	}
-
-	gl, err := NewWebGL(canvasEl)
-	if err != nil {
-		return nil, err
-	}
-
-	program, err := gl.CreateProgramFromSource(vertexShaderSource, fragmentShaderSource)
-	if err != nil {
-		return nil, err
-	}
-
-	positionLocation := gl.GetAttribLocation(program, "a_position")
-	texcoordLocation := gl.GetAttribLocation(program, "a_texcoord")
-
-	matrixLocation := gl.GetUniformLocation(program, "u_matrix")
-	textureLocation := gl.GetUniformLocation(program, "u_texture")
-
-	positionBuffer := gl.CreateBuffer()
-	gl.BindBuffer(gl.ArrayBuffer, positionBuffer)
-	positions := js.TypedArrayOf([]float32{
-		0, 0,
-		0, 1,
-		1, 0,
-		1, 0,
-		0, 1,
-		1, 1,
-	})
-	gl.BufferData(gl.ArrayBuffer, positions, gl.StaticDraw)
-
-	texCoordBuffer := gl.CreateBuffer()
-	gl.BindBuffer(gl.ArrayBuffer, texCoordBuffer)
-	texcoords := js.TypedArrayOf([]float32{
-		0, 0,
-		0, 1,
-		1, 0,
-		1, 0,
-		0, 1,
-		1, 1,
-	})
-	gl.BufferData(gl.ArrayBuffer, texcoords, gl.StaticDraw)
-
-	t := &TileRenderer{
-		gl:             gl,
-		program:        program,
-		position:       positionLocation,
-		positionBuffer: positionBuffer,
-		texcoord:       texcoordLocation,
-		texcoordBuffer: texCoordBuffer,
-		matrix:         matrixLocation,
-		texture:        textureLocation,
-		cache:          cache,
-	}
-
-	t.renderFrame = js.NewCallback(func(args []js.Value) { t.updateGl() })
-
-	return t, nil
-}
-
-// TileRenderer will render tiles onto a canvas using webgl
-type TileRenderer struct {
-	gl             *WebGL
-	program        js.Value
-	position       js.Value
-	positionBuffer js.Value
-	texcoord       js.Value
-	texcoordBuffer js.Value
-	matrix         js.Value
-	texture        js.Value
-	toDraw         []*drawInfo
-	cache          *lru.Cache
-	renderFrame    js.Callback
-}
-
-// Viewport returns the current width and height of the tile renderer's viewport
-func (t *TileRenderer) Viewport() (width, height float64) {
-	width = t.gl.Canvas().Get("width").Float()
-	height = t.gl.Canvas().Get("height").Float()
-	return
-}
-
-func (t *TileRenderer) updateGl() {
-	cWidth, cHeight := t.Viewport()
-
-	t.gl.Viewport(0, 0, cWidth, cHeight)
-
-	t.gl.ClearColor(0, 0, 0, 0)
-	t.gl.Clear(t.gl.ColorBufferBit)
-
-	centreX := cWidth / 2
-	centreY := cHeight / 2
-	for _, td := range t.toDraw {
-		t.drawImage(
-			td.Texture,
-			float32(centreX)-float32(td.DX),
-			float32(centreY)-float32(td.DY),
-			float32(td.Scale),
-		)
-	}
-}
-
-// RenderTiles will render the given tiles at the current zoom level
-func (t *TileRenderer) RenderTiles(zoom int, tiles map[string]*pichiwmap.Tile) {
-	// Cancel any loads that are no longer necessary
-	for _, td := range t.toDraw {
-		if _, ok := tiles[td.Texture.URL]; !ok {
-			if td.Texture.Cancel() {
-				t.cache.Remove(td.Texture.URL)
-			}
-		}
-	}
-
-	t.toDraw = nil
-
-	for _, tile := range tiles {
-		u := tile.URL.String()
-
-		var txi *textureInfo
-		v, ok := t.cache.Get(u)
-		if ok {
-			txi = v.(*textureInfo)
-		} else {
-			txi = t.loadImage(tile.URL.String(), t.imageLoadCallback)
-			t.cache.Add(u, txi)
-		}
-
-		if tile.Zoom == zoom {
-			t.toDraw = append(t.toDraw, &drawInfo{
-				Texture: txi,
-				DX:      tile.DX,
-				DY:      tile.DY,
-				Scale:   tile.Scale,
-			})
-		}
-	}
-	t.requestAnimationFrame()
-}
-
-func (t *TileRenderer) imageLoadCallback(txi *textureInfo) {
-	t.requestAnimationFrame()
-}
-
-func (t *TileRenderer) requestAnimationFrame() {
-	js.Global().Call("requestAnimationFrame", t.renderFrame)
-}
-
-func (t *TileRenderer) drawImage(tex *textureInfo, dstX, dstY, scale float32) {
-	cwidth, cheight := t.Viewport()
-
-	t.gl.BindTexture(t.gl.Texture2D, tex.Texture)
-	t.gl.UseProgram(t.program)
-	t.gl.BindBuffer(t.gl.ArrayBuffer, t.positionBuffer)
-	t.gl.EnableVertexAttribArray(t.position)
-	t.gl.VertexAttribPointer(t.position, 2, t.gl.Float, false, 0, 0)
-	t.gl.BindBuffer(t.gl.ArrayBuffer, t.texcoordBuffer)
-	t.gl.EnableVertexAttribArray(t.texcoord)
-	t.gl.VertexAttribPointer(t.texcoord, 2, t.gl.Float, false, 0, 0)
-
-	matrix := Orthographic(0, float32(cwidth), float32(cheight), 0, -1, 1)
-	matrix = matrix.Translate(dstX, dstY, 0)
-	matrix = matrix.Scale(float32(tex.Width)*scale, float32(tex.Height)*scale, 1)
-
-	t.gl.UniformMatrix4fv(t.matrix, false, matrix)
-	t.gl.Uniform1i(t.texture, 0)
-	t.gl.DrawArrays(t.gl.Triangles, 0, 6)
-}
-
-type textureInfo struct {
-	m         sync.Mutex
-	URL       string
-	Width     int // we don't know the size until it loads
-	Height    int
-	Texture   js.Value
-	Image     js.Value
-	Loaded    bool
-	Cancelled bool
-}
-
-func (t *textureInfo) Cancel() bool {
-	t.m.Lock()
-	defer t.m.Unlock()
-
-	if t.Loaded || t.Cancelled {
-		return false // Don't cancel if it's already loaded!
-	}
-	t.Cancelled = true
-	t.Image.Set("src", "")
-	return true
-}
-
-var blankTexture js.TypedArray
-
-func init() {
-	bt := make([]uint8, pichiwmap.TileWidth*pichiwmap.TileHeight*4)
-
-	for i := 0; i < len(bt); i += 4 {
-		bt[i] = 0
-		bt[i+1] = 0
-		bt[i+2] = 0
-		bt[i+3] = 30
-	}
-
-	blankTexture = js.TypedArrayOf(bt)
-}
-
-func (t *TileRenderer) loadImage(url string, onLoad func(txi *textureInfo)) *textureInfo {
-	tex := t.gl.CreateTexture()
-	t.gl.BindTexture(t.gl.Texture2D, tex)
-	t.gl.TexImage2DColor(t.gl.Texture2D, 0, t.gl.RGBA, pichiwmap.TileWidth, pichiwmap.TileHeight, 0, t.gl.RGBA, t.gl.UnsignedByte, blankTexture)
-	t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureWrapS, t.gl.ClampToEdge)
-	t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureWrapT, t.gl.ClampToEdge)
-	t.gl.TexParameteri(t.gl.Texture2D, t.gl.TextureMinFilter, t.gl.Linear)
-
-	txi := &textureInfo{
-		URL:     url,
-		Width:   pichiwmap.TileWidth,
-		Height:  pichiwmap.TileHeight,
-		Texture: tex,
-		Image:   js.Global().Get("Image").New(),
-	}
-
-	txi.Image.Call("addEventListener", "load", js.NewEventCallback(0, func(event js.Value) {
-		txi.m.Lock()
-		defer txi.m.Unlock()
-
-		txi.Loaded = true
-
-		txi.Width = txi.Image.Get("width").Int()
-		txi.Height = txi.Image.Get("height").Int()
-
-		t.gl.BindTexture(t.gl.Texture2D, txi.Texture)
-		t.gl.TexImage2DData(t.gl.Texture2D, 0, t.gl.RGBA, t.gl.RGBA, t.gl.UnsignedByte, txi.Image)
-		onLoad(txi)
-	}))
-	txi.Image.Set("crossOrigin", "")
-	txi.Image.Set("src", url)
-	return txi
-}
-
-type drawInfo struct {
-	Texture *textureInfo
-	DX      int
-	DY      int
-	Scale   float64
-}
-
-const vertexShaderSource = `
-attribute vec4 a_position;
-attribute vec2 a_texcoord;
- 
-uniform mat4 u_matrix;
- 
-varying vec2 v_texcoord;
- 
-void main() {
-   gl_Position = u_matrix * a_position;
-   v_texcoord = a_texcoord;
-}
-`
-
-const fragmentShaderSource = `
-precision mediump float;
- 
-varying vec2 v_texcoord;
- 
-uniform sampler2D u_texture;
- 
-void main() {
-   gl_FragColor = texture2D(u_texture, v_texcoord);
-}
-`
+package pmwgl
+
+import (
+	"sync"
+	"syscall/js"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pichiw/pichiwmap"
+)
+
+// NewTileRenderer creates a new tile renderer, picking the best available
+// Driver for canvasEl.
+func NewTileRenderer(canvasEl js.Value) (*TileRenderer, error) {
+	cache, err := lru.New(150)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := newDriver(canvasEl)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &TileRenderer{
+		driver: driver,
+		cache:  cache,
+	}
+
+	return t, nil
+}
+
+// newDriver picks WebGL if canvasEl supports it, falling back to Canvas2D
+// (no batching, no custom shaders, no framebuffers) so the map still
+// renders somewhere on browsers without WebGL.
+func newDriver(canvasEl js.Value) (Driver, error) {
+	if w, err := newWGLDriver(canvasEl); err == nil {
+		return w, nil
+	}
+	return newCanvas2dDriver(canvasEl)
+}
+
+// TileRenderer schedules which tiles to draw and when, and hands batches of
+// already-positioned quads to a Driver to actually put pixels on screen. It
+// has no WebGL (or any other graphics API) calls of its own.
+type TileRenderer struct {
+	driver Driver
+	toDraw []*drawInfo
+	layers []*Layer
+	cache  *lru.Cache
+}
+
+// Viewport returns the current width and height of the tile renderer's viewport
+func (t *TileRenderer) Viewport() (width, height float64) {
+	return t.driver.Viewport()
+}
+
+// GL returns the *WebGL backing t, for constructing a Framebuffer via
+// NewFramebuffer. ok is false under a non-WebGL Driver such as
+// canvas2dDriver.
+func (t *TileRenderer) GL() (gl *WebGL, ok bool) {
+	w, ok := t.driver.(*wglDriver)
+	if !ok {
+		return nil, false
+	}
+	return w.gl, true
+}
+
+func (t *TileRenderer) updateGl() {
+	width, height := t.driver.Viewport()
+
+	if t.layers != nil {
+		t.drawLayers(width, height)
+		return
+	}
+
+	t.driver.DrawQuads(quadsFor(t.toDraw, width, height), true, 1, CompositeModeSourceOver)
+}
+
+// drawLayers draws each of t.layers on top of the last: the target is
+// cleared once before the first layer, and later layers composite over
+// what's already there according to their own Opacity/Composite. If layers
+// is non-nil but empty (every overlay toggled off) there's no first layer
+// left to carry that clear, so clear explicitly instead of leaving the
+// previous frame on screen.
+func (t *TileRenderer) drawLayers(width, height float64) {
+	if len(t.layers) == 0 {
+		t.driver.DrawQuads(nil, true, 1, CompositeModeSourceOver)
+		return
+	}
+	for i, l := range t.layers {
+		t.driver.DrawQuads(quadsFor(l.toDraw, width, height), i == 0, l.Opacity, l.Composite)
+	}
+}
+
+// quadsFor converts toDraw into the Quad batch a Driver draws, centred on a
+// width x height viewport. Tiles whose image hasn't finished loading are
+// still included, drawn with the semi-transparent placeholder texture
+// loadImage/CreateTexture pre-filled them with, so the map shows that
+// placeholder instead of a gap until each tile's imageLoadCallback fires
+// and requests another frame.
+func quadsFor(toDraw []*drawInfo, width, height float64) []Quad {
+	centreX := float32(width) / 2
+	centreY := float32(height) / 2
+
+	quads := make([]Quad, 0, len(toDraw))
+	for _, td := range toDraw {
+		quads = append(quads, Quad{
+			Texture: td.Texture.Texture,
+			X:       centreX - float32(td.DX),
+			Y:       centreY - float32(td.DY),
+			Width:   float32(td.Texture.Width) * float32(td.Scale),
+			Height:  float32(td.Texture.Height) * float32(td.Scale),
+		})
+	}
+	return quads
+}
+
+// RenderTiles will render the given tiles at the current zoom level
+func (t *TileRenderer) RenderTiles(zoom int, tiles map[string]*pichiwmap.Tile) {
+	// Abandoning RenderLayers mode orphans every layer's in-flight loads
+	// unless we cancel them here; RenderLayers won't be called again to do
+	// it for us.
+	for _, l := range t.layers {
+		cancelToDraw(l.toDraw, l.cache)
+		l.toDraw = nil
+	}
+	t.layers = nil
+
+	// Cancel any loads that are no longer necessary
+	for _, td := range t.toDraw {
+		if _, ok := tiles[td.Texture.URL]; !ok {
+			if td.Texture.Cancel() {
+				t.cache.Remove(td.Texture.URL)
+			}
+		}
+	}
+
+	t.toDraw = nil
+
+	for _, tile := range tiles {
+		u := tile.URL.String()
+
+		var txi *textureInfo
+		v, ok := t.cache.Get(u)
+		if ok {
+			txi = v.(*textureInfo)
+		} else {
+			txi = t.loadImage(tile.URL.String(), t.imageLoadCallback)
+			t.cache.Add(u, txi)
+		}
+
+		if tile.Zoom == zoom {
+			t.toDraw = append(t.toDraw, &drawInfo{
+				Texture: txi,
+				DX:      tile.DX,
+				DY:      tile.DY,
+				Scale:   tile.Scale,
+			})
+		}
+	}
+	t.requestAnimationFrame()
+}
+
+func (t *TileRenderer) imageLoadCallback(txi *textureInfo) {
+	t.requestAnimationFrame()
+}
+
+func (t *TileRenderer) requestAnimationFrame() {
+	t.driver.RequestFrame(t.updateGl)
+}
+
+type textureInfo struct {
+	m         sync.Mutex
+	URL       string
+	Width     int // we don't know the size until it loads
+	Height    int
+	Texture   js.Value
+	Image     js.Value
+	Loaded    bool
+	Cancelled bool
+}
+
+func (t *textureInfo) Cancel() bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.Loaded || t.Cancelled {
+		return false // Don't cancel if it's already loaded!
+	}
+	t.Cancelled = true
+	t.Image.Set("src", "")
+	return true
+}
+
+func (t *TileRenderer) loadImage(url string, onLoad func(txi *textureInfo)) *textureInfo {
+	tex := t.driver.CreateTexture(pichiwmap.TileWidth, pichiwmap.TileHeight)
+
+	txi := &textureInfo{
+		URL:     url,
+		Width:   pichiwmap.TileWidth,
+		Height:  pichiwmap.TileHeight,
+		Texture: tex,
+		Image:   js.Global().Get("Image").New(),
+	}
+
+	txi.Image.Call("addEventListener", "load", js.NewEventCallback(0, func(event js.Value) {
+		txi.m.Lock()
+		defer txi.m.Unlock()
+
+		txi.Loaded = true
+
+		txi.Width = txi.Image.Get("width").Int()
+		txi.Height = txi.Image.Get("height").Int()
+
+		t.driver.UploadImage(txi.Texture, txi.Image)
+		onLoad(txi)
+	}))
+	txi.Image.Set("crossOrigin", "")
+	txi.Image.Set("src", url)
+	return txi
+}
+
+type drawInfo struct {
+	Texture *textureInfo
+	DX      int
+	DY      int
+	Scale   float64
+}
+
+// cancelToDraw cancels every in-flight load referenced by toDraw and evicts
+// it from cache. Used when toDraw's whole owner — the single-layer toDraw
+// RenderTiles keeps directly on TileRenderer, or a Layer — is being
+// abandoned wholesale (a RenderTiles/RenderLayers mode switch, or a layer
+// dropped between RenderLayers calls), rather than just losing the handful
+// of tiles a normal diff against the next tile set would catch.
+func cancelToDraw(toDraw []*drawInfo, cache *lru.Cache) {
+	for _, td := range toDraw {
+		if td.Texture.Cancel() {
+			cache.Remove(td.Texture.URL)
+		}
+	}
+}
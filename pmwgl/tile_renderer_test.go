@@ -0,0 +1,56 @@
+package pmwgl
+
+import "testing"
+
+func TestQuadsFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		toDraw []*drawInfo
+		want   int
+	}{
+		{
+			name: "loaded and not-yet-loaded tiles both draw",
+			toDraw: []*drawInfo{
+				{Texture: &textureInfo{Width: 256, Height: 256, Loaded: true}, Scale: 1},
+				{Texture: &textureInfo{Width: 256, Height: 256, Loaded: false}, Scale: 1},
+			},
+			want: 2,
+		},
+		{
+			name:   "empty toDraw produces no quads",
+			toDraw: nil,
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quads := quadsFor(tt.toDraw, 512, 512)
+			if len(quads) != tt.want {
+				t.Fatalf("quadsFor() returned %d quads, want %d", len(quads), tt.want)
+			}
+		})
+	}
+}
+
+func TestQuadsForCentering(t *testing.T) {
+	toDraw := []*drawInfo{
+		{Texture: &textureInfo{Width: 256, Height: 256}, DX: 10, DY: 20, Scale: 2},
+	}
+
+	quads := quadsFor(toDraw, 512, 512)
+	if len(quads) != 1 {
+		t.Fatalf("quadsFor() returned %d quads, want 1", len(quads))
+	}
+
+	q := quads[0]
+	if want := float32(256 - 10); q.X != want {
+		t.Errorf("quadsFor() X = %v, want %v", q.X, want)
+	}
+	if want := float32(256 - 20); q.Y != want {
+		t.Errorf("quadsFor() Y = %v, want %v", q.Y, want)
+	}
+	if want := float32(512); q.Width != want || q.Height != want {
+		t.Errorf("quadsFor() size = %vx%v, want %vx%v", q.Width, q.Height, want, want)
+	}
+}
@@ -0,0 +1,119 @@
+package pmwgl
+
+import "syscall/js"
+
+// TileShader is a compiled GL program used to draw batched tile quads. It
+// always pairs with the renderer's standard vertex shader (which bakes
+// screen-space position, texcoord, and texture-unit index per vertex) but
+// lets callers supply their own fragment shader and extra uniforms, so the
+// same tiles can be rendered as grayscale, night-mode, sepia, hillshaded,
+// etc. without swapping tile sources.
+type TileShader struct {
+	gl       *WebGL
+	Program  js.Value
+	Uniforms map[string]js.Value
+
+	position js.Value
+	texcoord js.Value
+	texIndex js.Value
+	matrix   js.Value
+	textures js.Value
+	opacity  js.Value
+}
+
+// NewTileShader compiles fragmentSource against the renderer's batched-quad
+// vertex shader and resolves the given extra uniform names so they can be
+// driven later via SetUniform1f/SetUniform4f. fragmentSource should declare
+// a `uniform float u_opacity;` and multiply it into the output alpha if the
+// shader is meant to be usable as a RenderLayers layer. If it doesn't,
+// opacity resolves to an unresolved location, and draw() setting it anyway
+// is a safe no-op per the WebGL spec.
+func NewTileShader(gl *WebGL, fragmentSource string, uniformNames ...string) (*TileShader, error) {
+	program, err := gl.CreateProgramFromSource(vertexShaderSource, fragmentSource)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TileShader{
+		gl:      gl,
+		Program: program,
+
+		position: gl.GetAttribLocation(program, "a_position"),
+		texcoord: gl.GetAttribLocation(program, "a_texcoord"),
+		texIndex: gl.GetAttribLocation(program, "a_texIndex"),
+		matrix:   gl.GetUniformLocation(program, "u_matrix"),
+		textures: gl.GetUniformLocation(program, "u_textures"),
+		opacity:  gl.GetUniformLocation(program, "u_opacity"),
+
+		Uniforms: make(map[string]js.Value, len(uniformNames)),
+	}
+
+	for _, name := range uniformNames {
+		s.Uniforms[name] = gl.GetUniformLocation(program, name)
+	}
+
+	return s, nil
+}
+
+// SetUniform1f sets a float uniform declared via NewTileShader's uniformNames.
+func (s *TileShader) SetUniform1f(name string, v float32) {
+	s.gl.Uniform1f(s.Uniforms[name], v)
+}
+
+// SetUniform4f sets a vec4 uniform declared via NewTileShader's uniformNames.
+func (s *TileShader) SetUniform4f(name string, x, y, z, w float32) {
+	s.gl.Uniform4f(s.Uniforms[name], x, y, z, w)
+}
+
+// SetShader switches the shader program used for subsequent tile draws.
+// Pass nil to go back to the renderer's default (unmodified) tile shader.
+// Custom shaders are a WebGL concept: this is a no-op under a non-WebGL
+// Driver such as canvas2dDriver.
+func (t *TileRenderer) SetShader(s *TileShader) {
+	w, ok := t.driver.(*wglDriver)
+	if !ok {
+		return
+	}
+	if s == nil {
+		s = w.defaultShader
+	}
+	w.shader = s
+}
+
+const vertexShaderSource = `
+attribute vec2 a_position;
+attribute vec2 a_texcoord;
+attribute float a_texIndex;
+
+uniform mat4 u_matrix;
+
+varying vec2 v_texcoord;
+varying float v_texIndex;
+
+void main() {
+   gl_Position = u_matrix * vec4(a_position, 0.0, 1.0);
+   v_texcoord = a_texcoord;
+   v_texIndex = a_texIndex;
+}
+`
+
+const fragmentShaderSource = `
+precision mediump float;
+
+varying vec2 v_texcoord;
+varying float v_texIndex;
+
+uniform sampler2D u_textures[8];
+uniform float u_opacity;
+
+void main() {
+   vec4 color = vec4(0.0);
+   for (int i = 0; i < 8; i++) {
+      if (i == int(v_texIndex)) {
+         color = texture2D(u_textures[i], v_texcoord);
+      }
+   }
+   color.a *= u_opacity;
+   gl_FragColor = color;
+}
+`
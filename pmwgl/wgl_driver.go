@@ -0,0 +1,204 @@
+package pmwgl
+
+import (
+	"syscall/js"
+
+	"github.com/pichiw/pichiwmap"
+)
+
+// maxBatchTextures is the number of texture units the batched draw rotates
+// through before it has to flush and start a new DrawArrays call. It's kept
+// low enough to stay within the guaranteed minimum of MAX_TEXTURE_IMAGE_UNITS
+// on WebGL1 hardware.
+const maxBatchTextures = 8
+
+// floatsPerVertex is the stride of the interleaved batch buffer: screen-space
+// x/y (already translated+scaled on the CPU), texcoord u/v, and the texture
+// unit index the fragment shader should sample from.
+const floatsPerVertex = 5
+
+// verticesPerQuad is two triangles (no element buffer).
+const verticesPerQuad = 6
+
+// wglDriver is the WebGL-backed Driver. It batches tile quads into one
+// interleaved vertex buffer per maxBatchTextures-sized group and issues a
+// single DrawArrays per group, rotating through texture units instead of
+// binding a texture and drawing per tile.
+type wglDriver struct {
+	gl                *WebGL
+	defaultShader     *TileShader
+	shader            *TileShader
+	batchBuffer       js.Value
+	state             *glStateCache
+	screenFramebuffer js.Value
+
+	frameCb    js.Callback
+	frameFn    func()
+	frameCbSet bool
+}
+
+// newWGLDriver compiles the default tile shader and sets up the shared
+// batch vertex buffer against canvasEl's WebGL context.
+func newWGLDriver(canvasEl js.Value) (*wglDriver, error) {
+	gl, err := NewWebGL(canvasEl)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultShader, err := NewTileShader(gl, fragmentShaderSource)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wglDriver{
+		gl:            gl,
+		defaultShader: defaultShader,
+		shader:        defaultShader,
+		batchBuffer:   gl.CreateBuffer(),
+		state:         newGLStateCache(),
+		// The default framebuffer isn't always WebGL's `null` (e.g. when
+		// the canvas context is itself composited into a larger surface),
+		// so remember whatever was bound at construction time.
+		screenFramebuffer: gl.GetParameter(gl.FramebufferBinding),
+	}, nil
+}
+
+var blankTexture js.TypedArray
+
+func init() {
+	bt := make([]uint8, pichiwmap.TileWidth*pichiwmap.TileHeight*4)
+
+	for i := 0; i < len(bt); i += 4 {
+		bt[i] = 0
+		bt[i+1] = 0
+		bt[i+2] = 0
+		bt[i+3] = 30
+	}
+
+	blankTexture = js.TypedArrayOf(bt)
+}
+
+func (w *wglDriver) CreateTexture(width, height int) js.Value {
+	tex := w.gl.CreateTexture()
+	w.bindTextureUnit(0, tex)
+	w.gl.TexImage2DColor(w.gl.Texture2D, 0, w.gl.RGBA, width, height, 0, w.gl.RGBA, w.gl.UnsignedByte, blankTexture)
+	w.gl.TexParameteri(w.gl.Texture2D, w.gl.TextureWrapS, w.gl.ClampToEdge)
+	w.gl.TexParameteri(w.gl.Texture2D, w.gl.TextureWrapT, w.gl.ClampToEdge)
+	w.gl.TexParameteri(w.gl.Texture2D, w.gl.TextureMinFilter, w.gl.Linear)
+	return tex
+}
+
+func (w *wglDriver) UploadImage(tex js.Value, img js.Value) {
+	w.bindTextureUnit(0, tex)
+	w.gl.TexImage2DData(w.gl.Texture2D, 0, w.gl.RGBA, w.gl.RGBA, w.gl.UnsignedByte, img)
+}
+
+func (w *wglDriver) Viewport() (width, height float64) {
+	width = w.gl.Canvas().Get("width").Float()
+	height = w.gl.Canvas().Get("height").Float()
+	return
+}
+
+func (w *wglDriver) DrawQuads(quads []Quad, clear bool, opacity float32, composite CompositeMode) {
+	width, height := w.Viewport()
+	w.draw(quads, clear, opacity, composite, width, height)
+}
+
+// draw is DrawQuads with an explicit target size, so RenderToFramebuffer
+// can reuse it against a framebuffer-sized target instead of the canvas.
+func (w *wglDriver) draw(quads []Quad, clear bool, opacity float32, composite CompositeMode, targetWidth, targetHeight float64) {
+	w.setViewport(targetWidth, targetHeight)
+
+	if clear {
+		w.gl.ClearColor(0, 0, 0, 0)
+		w.gl.Clear(w.gl.ColorBufferBit)
+	}
+
+	if len(quads) == 0 {
+		return
+	}
+
+	w.gl.Enable(w.gl.Blend)
+	w.setBlendMode(composite)
+
+	matrix := Orthographic(0, float32(targetWidth), float32(targetHeight), 0, -1, 1)
+
+	shader := w.shader
+	w.useProgram(shader.Program)
+	w.gl.UniformMatrix4fv(shader.matrix, false, matrix)
+	// shader may be a custom TileShader authored before u_opacity existed,
+	// in which case shader.opacity is an unresolved location; the WebGL
+	// spec makes a Uniform* call against an unresolved location a no-op, so
+	// it's safe to always set it here instead of branching on whether the
+	// active shader's fragment source actually declares u_opacity.
+	w.gl.Uniform1f(shader.opacity, opacity)
+
+	w.gl.BindBuffer(w.gl.ArrayBuffer, w.batchBuffer)
+	w.gl.EnableVertexAttribArray(shader.position)
+	w.gl.EnableVertexAttribArray(shader.texcoord)
+	w.gl.EnableVertexAttribArray(shader.texIndex)
+
+	stride := floatsPerVertex * 4 // bytes
+	w.gl.VertexAttribPointerOffset(shader.position, 2, w.gl.Float, false, stride, 0)
+	w.gl.VertexAttribPointerOffset(shader.texcoord, 2, w.gl.Float, false, stride, 2*4)
+	w.gl.VertexAttribPointerOffset(shader.texIndex, 1, w.gl.Float, false, stride, 4*4)
+
+	// Rotate through texture units in batches of maxBatchTextures so a
+	// whole viewport's worth of tiles costs one setup plus one DrawArrays
+	// per batch, instead of one full bind+draw per tile.
+	for start := 0; start < len(quads); start += maxBatchTextures {
+		end := start + maxBatchTextures
+		if end > len(quads) {
+			end = len(quads)
+		}
+		w.drawBatch(quads[start:end])
+	}
+}
+
+// drawBatch uploads one interleaved vertex buffer covering every quad in
+// batch and issues a single DrawArrays, binding each distinct tile texture
+// once to its own texture unit.
+func (w *wglDriver) drawBatch(batch []Quad) {
+	verts := make([]float32, 0, len(batch)*verticesPerQuad*floatsPerVertex)
+
+	for i, q := range batch {
+		w.bindTextureUnit(i, q.Texture)
+		verts = append(verts, quadVertices(q.X, q.Y, q.Width, q.Height, float32(i))...)
+	}
+
+	units := make([]int, len(batch))
+	for i := range batch {
+		units[i] = i
+	}
+	w.gl.Uniform1iv(w.shader.textures, units)
+
+	w.gl.BufferData(w.gl.ArrayBuffer, js.TypedArrayOf(verts), w.gl.DynamicDraw)
+	w.gl.DrawArrays(w.gl.Triangles, 0, len(batch)*verticesPerQuad)
+}
+
+// quadVertices returns the six vertices (two triangles) of a quad at
+// (x, y) with the given width/height, tagged with texIdx so the shared
+// fragment shader knows which texture unit to sample.
+func quadVertices(x, y, w, h, texIdx float32) []float32 {
+	return []float32{
+		x, y, 0, 0, texIdx,
+		x, y + h, 0, 1, texIdx,
+		x + w, y, 1, 0, texIdx,
+		x + w, y, 1, 0, texIdx,
+		x, y + h, 0, 1, texIdx,
+		x + w, y + h, 1, 1, texIdx,
+	}
+}
+
+func (w *wglDriver) RequestFrame(cb func()) {
+	w.frameFn = cb
+	if !w.frameCbSet {
+		w.frameCb = js.NewCallback(func(args []js.Value) {
+			if w.frameFn != nil {
+				w.frameFn()
+			}
+		})
+		w.frameCbSet = true
+	}
+	js.Global().Call("requestAnimationFrame", w.frameCb)
+}